@@ -0,0 +1,151 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package enjarify provides a streaming, library-friendly entry point to
+// the DEX-to-JVM translator. It exposes the same translation pipeline used
+// by the enjarify-go command line tool as composable io.Reader/io.Writer
+// based APIs, so callers can embed DEX->JAR conversion in their own
+// pipelines without shelling out to the binary or touching the filesystem.
+package enjarify
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"io/ioutil"
+	"unicode"
+	"unicode/utf16"
+
+	"enjarify-go/dex"
+	"enjarify-go/jvm"
+)
+
+// Class is a single translated JVM class file, keyed by its fully
+// qualified, slash-separated class file name (e.g. "foo/Bar.class"). Err
+// is set if translation of this particular class failed; Data is only
+// valid when Err is nil. Split names the DexSource split the class's DEX
+// file came from (e.g. an App Bundle split name), and is empty for input
+// that isn't split-aware, such as a bare DEX file.
+type Class struct {
+	Name  string
+	Data  string
+	Err   error
+	Split string
+}
+
+// Translate parses a single DEX file read from r and translates its
+// classes to JVM class files, opts controlling the translation mode (see
+// jvm.PRETTY and jvm.NONE). split is recorded on every emitted Class and
+// is otherwise not interpreted by Translate; pass "" if the caller has
+// nothing meaningful to annotate classes with. Classes are sent to the
+// returned channel in the order they appear in the DEX file as they are
+// translated, which the caller may cancel early via ctx; the channel is
+// closed once translation finishes or ctx is done.
+//
+// Translate does not deduplicate class names across multiple calls -
+// callers translating several DEX files into one output (e.g. a multi-dex
+// APK) are responsible for merging the resulting channels and handling
+// duplicates themselves.
+//
+// A single malformed class can't crash the caller: translateOne recovers
+// any panic from jvm.ToClassFile into the emitted Class's Err field.
+func Translate(ctx context.Context, split string, r io.Reader, opts jvm.Options) (<-chan Class, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := dex.Parse(string(data))
+	out := make(chan Class)
+
+	go func() {
+		defer close(out)
+		for _, cls := range d.Classes {
+			select {
+			case out <- translateOne(cls, split, opts):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// WriteJar consumes classes, writing each one whose Err is nil as an entry
+// of a zip/jar archive to w in the order received; classes with Err set
+// are skipped. It stops and returns the first
+// write error encountered, draining the rest of the channel so the
+// producing goroutine started by Translate is never left blocked.
+func WriteJar(w io.Writer, classes <-chan Class) error {
+	zw := zip.NewWriter(w)
+
+	var werr error
+	for cls := range classes {
+		if werr != nil || cls.Err != nil {
+			continue
+		}
+
+		f, err := zw.Create(cls.Name)
+		if err != nil {
+			werr = err
+			continue
+		}
+		if _, err := f.Write([]byte(cls.Data)); err != nil {
+			werr = err
+			continue
+		}
+	}
+	if werr != nil {
+		zw.Close()
+		return werr
+	}
+	return zw.Close()
+}
+
+// Decode converts name, which is encoded in the modified UTF-8 (MUTF-8)
+// format used for strings in DEX files, to a normal Go string.
+func Decode(name string) string {
+	b := []byte(name)
+	runes := make([]rune, 0, len(b))
+
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c&0x80 == 0:
+			runes = append(runes, rune(c))
+			i++
+		case c&0xE0 == 0xC0 && i+1 < len(b):
+			runes = append(runes, rune(c&0x1F)<<6|rune(b[i+1]&0x3F))
+			i += 2
+		case c&0xF0 == 0xE0 && i+2 < len(b):
+			r := rune(c&0x0F)<<12 | rune(b[i+1]&0x3F)<<6 | rune(b[i+2]&0x3F)
+			i += 3
+			// MUTF-8 encodes supplementary characters as a surrogate pair
+			// of two 3-byte sequences.
+			if utf16.IsSurrogate(r) && i+2 < len(b) && b[i]&0xF0 == 0xE0 {
+				r2 := rune(b[i]&0x0F)<<12 | rune(b[i+1]&0x3F)<<6 | rune(b[i+2]&0x3F)
+				if dec := utf16.DecodeRune(r, r2); dec != unicode.ReplacementChar {
+					r = dec
+					i += 3
+				}
+			}
+			runes = append(runes, r)
+		default:
+			runes = append(runes, rune(c))
+			i++
+		}
+	}
+	return string(runes)
+}
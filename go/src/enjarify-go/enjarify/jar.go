@@ -0,0 +1,65 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package enjarify
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// manifest is the minimal META-INF/MANIFEST.MF enjarify-go writes into
+// reproducible JARs, since some JVM tools complain about its absence.
+const manifest = "Manifest-Version: 1.0\n"
+
+// DefaultEpoch is the fixed modification time to pass to
+// NewReproducibleJarSink (and the other reproducible sinks) when the
+// caller doesn't have a more specific SOURCE_DATE_EPOCH to honor.
+// 1980-01-01 is the earliest timestamp the zip format can represent.
+var DefaultEpoch = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// SourceDateEpoch returns the timestamp reproducible builds should use,
+// per the https://reproducible-builds.org/docs/source-date-epoch/
+// convention: the Unix time in the SOURCE_DATE_EPOCH environment
+// variable, or fallback if it isn't set or isn't a valid integer.
+func SourceDateEpoch(fallback time.Time) time.Time {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return fallback
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// NewReproducibleJarSink returns a ClassSink like NewJarSink, except every
+// entry's modified time is recorded as epoch rather than left unset, each
+// entry is written with a stable external attributes field and DEFLATE
+// compression, and a minimal META-INF/MANIFEST.MF is written first - so
+// two independent runs over the same classes in the same order produce a
+// byte-identical JAR. Reproducibility also depends on the caller Put'ing
+// classes in a stable order; sort the class names before writing them if
+// the order coming out of translation isn't already stable.
+func NewReproducibleJarSink(w io.Writer, epoch time.Time) (ClassSink, error) {
+	s := &jarSink{w: zip.NewWriter(w), reproducible: true, epoch: epoch}
+	if err := s.put("META-INF/MANIFEST.MF", []byte(manifest)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
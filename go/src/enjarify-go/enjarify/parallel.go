@@ -0,0 +1,120 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package enjarify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"enjarify-go/dex"
+	"enjarify-go/jvm"
+)
+
+// job pairs a class with its position in the DEX file's class list, so
+// results can be flushed back out in that same order regardless of which
+// worker finished it or when.
+type job struct {
+	index int
+	cls   dex.Class
+}
+
+// TranslateParallel is like Translate, but dispatches the per-class
+// jvm.ToClassFile calls across a pool of workers goroutines instead of
+// running them one at a time. Each class's result is written into a
+// shared, index-addressed slice as its worker finishes, and only once
+// every class has been translated are the results flushed to the returned
+// channel in original class order - so the resulting JAR is byte-identical
+// to one produced by Translate, no matter how many workers were used.
+//
+// workers <= 1 skips the pool entirely and delegates straight to
+// Translate, which recovers per-class panics via the same translateOne
+// used here - so a malformed class is handled identically (as a
+// Class.Err, not a crash) no matter what -j is set to.
+func TranslateParallel(ctx context.Context, split string, r io.Reader, opts jvm.Options, workers int) (<-chan Class, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if workers <= 1 {
+		return Translate(ctx, split, bytes.NewReader(data), opts)
+	}
+
+	d := dex.Parse(string(data))
+	out := make(chan Class)
+
+	go func() {
+		defer close(out)
+
+		results := make([]Class, len(d.Classes))
+		jobs := make(chan job, workers)
+
+		var wg sync.WaitGroup
+		wg.Add(workers)
+		for i := 0; i < workers; i++ {
+			go func() {
+				defer wg.Done()
+				for j := range jobs {
+					results[j.index] = translateOne(j.cls, split, opts)
+				}
+			}()
+		}
+
+	send:
+		for i, cls := range d.Classes {
+			select {
+			case jobs <- job{i, cls}:
+			case <-ctx.Done():
+				break send
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		for _, cls := range results {
+			select {
+			case out <- cls:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// translateOne runs jvm.ToClassFile for a single class, recovering any
+// panic into a Class.Err so that a single bad class can't take down the
+// rest of the worker pool - or, via Translate, the calling goroutine when
+// there is no pool at all.
+func translateOne(cls dex.Class, split string, opts jvm.Options) (result Class) {
+	name := Decode(cls.Name) + ".class"
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = Class{Name: name, Err: fmt.Errorf("panic translating %s: %v", name, r), Split: split}
+		}
+	}()
+
+	data, err := jvm.ToClassFile(cls, opts)
+	return Class{Name: name, Data: data, Err: err, Split: split}
+}
@@ -0,0 +1,196 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package enjarify
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ClassSink accepts translated classes and commits them to some
+// destination - a JAR file, an exploded directory tree, a tar stream, or
+// an in-memory map, depending on the implementation. Callers must call
+// Put with classes in the order they want them to appear in the output,
+// then Close once to finalize it.
+type ClassSink interface {
+	Put(name string, data string) error
+	Close() error
+}
+
+// jarSink writes classes as entries of a zip/jar archive. See jar.go for
+// its reproducible-output mode.
+type jarSink struct {
+	w *zip.Writer
+
+	reproducible bool
+	epoch        time.Time
+}
+
+// NewJarSink returns a ClassSink that writes classes as a JAR (zip)
+// archive to w, with entries in Put order. Entries are created with
+// zip.Writer.Create, so no modification time is recorded for them. For
+// pinned timestamps, stable attributes and a manifest, use
+// NewReproducibleJarSink instead.
+func NewJarSink(w io.Writer) ClassSink {
+	return &jarSink{w: zip.NewWriter(w)}
+}
+
+func (s *jarSink) Put(name string, data string) error {
+	return s.put(name, []byte(data))
+}
+
+func (s *jarSink) put(name string, data []byte) error {
+	var f io.Writer
+	var err error
+	if s.reproducible {
+		f, err = s.w.CreateHeader(&zip.FileHeader{
+			Name:          name,
+			Method:        zip.Deflate,
+			Modified:      s.epoch,
+			ExternalAttrs: 0644 << 16,
+		})
+	} else {
+		f, err = s.w.Create(name)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func (s *jarSink) Close() error {
+	return s.w.Close()
+}
+
+// dirSink writes each class to its own file under an exploded directory
+// tree, e.g. class "foo/Bar.class" becomes the file dir/foo/Bar.class.
+type dirSink struct {
+	dir string
+
+	reproducible bool
+	epoch        time.Time
+}
+
+// NewDirSink returns a ClassSink that writes each class to its own file
+// under dir, creating parent directories as needed, with each file's
+// modified time following the wall clock. For byte-for-byte reproducible
+// trees (fixed mtimes), use NewReproducibleDirSink instead.
+func NewDirSink(dir string) ClassSink {
+	return dirSink{dir: dir}
+}
+
+// NewReproducibleDirSink is like NewDirSink, but sets every file's
+// modified time to epoch instead of the wall clock, matching the
+// reproducibility NewReproducibleJarSink gives JAR output.
+func NewReproducibleDirSink(dir string, epoch time.Time) ClassSink {
+	return dirSink{dir: dir, reproducible: true, epoch: epoch}
+}
+
+func (s dirSink) Put(name string, data string) error {
+	dir := filepath.Clean(s.dir)
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if path != dir && !strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+		return fmt.Errorf("enjarify: class name %q escapes output directory", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+		return err
+	}
+	if s.reproducible {
+		return os.Chtimes(path, s.epoch, s.epoch)
+	}
+	return nil
+}
+
+func (s dirSink) Close() error {
+	return nil
+}
+
+// tarSink writes classes as entries of a tar stream, for piping directly
+// into other tools without an intermediate JAR or directory.
+type tarSink struct {
+	w *tar.Writer
+
+	reproducible bool
+	epoch        time.Time
+}
+
+// NewTarSink returns a ClassSink that writes classes as a tar stream to w
+// (e.g. os.Stdout, for "-o -"), with each entry's modified time following
+// the wall clock. For byte-for-byte reproducible output, use
+// NewReproducibleTarSink instead.
+func NewTarSink(w io.Writer) ClassSink {
+	return &tarSink{w: tar.NewWriter(w)}
+}
+
+// NewReproducibleTarSink is like NewTarSink, but sets every entry's
+// modified time to epoch instead of the wall clock, matching the
+// reproducibility NewReproducibleJarSink gives JAR output.
+func NewReproducibleTarSink(w io.Writer, epoch time.Time) ClassSink {
+	return &tarSink{w: tar.NewWriter(w), reproducible: true, epoch: epoch}
+}
+
+func (s *tarSink) Put(name string, data string) error {
+	modTime := time.Now()
+	if s.reproducible {
+		modTime = s.epoch
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := s.w.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := s.w.Write([]byte(data))
+	return err
+}
+
+func (s *tarSink) Close() error {
+	return s.w.Close()
+}
+
+// MemSink collects classes into an in-memory map, for library callers and
+// tests that want translated classes without writing anything to disk.
+type MemSink struct {
+	Classes map[string][]byte
+}
+
+// NewMemSink returns a ClassSink that buffers classes in memory.
+func NewMemSink() *MemSink {
+	return &MemSink{Classes: make(map[string][]byte)}
+}
+
+func (s *MemSink) Put(name string, data string) error {
+	s.Classes[name] = []byte(data)
+	return nil
+}
+
+func (s *MemSink) Close() error {
+	return nil
+}
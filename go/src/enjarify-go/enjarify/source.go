@@ -0,0 +1,201 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package enjarify
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NamedDex is a single DEX file read from a DexSource, paired with the
+// name of the split (APK) it was found in. Split is "" for sources that
+// aren't split-aware, such as a bare DEX file.
+type NamedDex struct {
+	Split string
+	Data  string
+}
+
+// DexSource abstracts over where DEX files come from, so enjarify-go can
+// be extended to read from containers other than a single APK zip or a
+// bare DEX file - for example App Bundle splits, or DEX files recovered
+// from an obfuscated or encrypted container.
+type DexSource interface {
+	// Dexes returns every DEX file found in the source, in a stable,
+	// deterministic order: classes.dex, classes2.dex, ..., classes10.dex,
+	// not the lexicographic order of the entry names.
+	Dexes() ([]NamedDex, error)
+}
+
+// classesDexRe matches classesN.dex entries, capturing the optional
+// numeric suffix.
+var classesDexRe = regexp.MustCompile(`^classes(\d*)\.dex$`)
+
+// dexIndex returns the ordering index of a classesN.dex entry name (1 for
+// plain "classes.dex"), or -1 if name isn't a classes[N].dex entry.
+func dexIndex(name string) int {
+	m := classesDexRe.FindStringSubmatch(name)
+	if m == nil {
+		return -1
+	}
+	if m[1] == "" {
+		return 1
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// apkSource reads the classes*.dex entries directly out of a single APK
+// (or any other zip containing them at its top level), in dex-index
+// order. Every dex it returns is labeled with split.
+type apkSource struct {
+	split string
+	r     *zip.Reader
+}
+
+func (s apkSource) Dexes() ([]NamedDex, error) {
+	type entry struct {
+		index int
+		file  *zip.File
+	}
+	var entries []entry
+	for _, f := range s.r.File {
+		if idx := dexIndex(f.Name); idx >= 0 {
+			entries = append(entries, entry{idx, f})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].index < entries[j].index })
+
+	if len(entries) == 0 {
+		if s.split != "" {
+			return nil, fmt.Errorf("enjarify: no DEX files found in split %s", s.split)
+		}
+		return nil, fmt.Errorf("enjarify: no DEX files found in input")
+	}
+
+	res := make([]NamedDex, 0, len(entries))
+	for _, e := range entries {
+		rc, err := e.file.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, NamedDex{Split: s.split, Data: string(data)})
+	}
+	return res, nil
+}
+
+// bundleSource reads an Android App Bundle split archive (.apks/.xapk - a
+// zip of per-split APK zips), collecting the DEX files of every split it
+// contains, in split-name order, each labeled with its split's name.
+type bundleSource struct {
+	r *zip.Reader
+}
+
+func (s bundleSource) Dexes() ([]NamedDex, error) {
+	var splits []*zip.File
+	for _, f := range s.r.File {
+		if strings.HasSuffix(strings.ToLower(f.Name), ".apk") {
+			splits = append(splits, f)
+		}
+	}
+	sort.Slice(splits, func(i, j int) bool { return splits[i].Name < splits[j].Name })
+
+	if len(splits) == 0 {
+		return nil, fmt.Errorf("enjarify: no DEX files or APK splits found in input")
+	}
+
+	var res []NamedDex
+	for _, f := range splits {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		inner, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("split %s: %v", f.Name, err)
+		}
+
+		split := f.Name[:len(f.Name)-len(".apk")]
+		dexes, err := (apkSource{split: split, r: inner}).Dexes()
+		if err != nil {
+			return nil, fmt.Errorf("split %s: %v", f.Name, err)
+		}
+		res = append(res, dexes...)
+	}
+	return res, nil
+}
+
+// rawDexSource wraps a single already-decoded DEX file, for input that is
+// neither an APK nor a bundle.
+type rawDexSource struct {
+	data string
+}
+
+func (s rawDexSource) Dexes() ([]NamedDex, error) {
+	return []NamedDex{{Data: s.data}}, nil
+}
+
+var (
+	zipMagic = []byte("PK\x03\x04")
+	dexMagic = []byte("dex\n")
+)
+
+// OpenSource auto-detects the container format of data - a bare DEX file,
+// a single APK zip, or an App Bundle split archive (.apks/.xapk) - from
+// its magic bytes, telling an APK from a bundle by whether the zip has
+// classes*.dex entries at its top level, and returns a DexSource that reads its DEX
+// files back out in dex-index order. It does not consult the input's
+// filename, so callers can feed it arbitrarily-named or extensionless
+// input.
+func OpenSource(data string) (DexSource, error) {
+	b := []byte(data)
+	if len(b) < 4 || !bytes.Equal(b[:4], zipMagic) {
+		if len(b) >= 4 && bytes.Equal(b[:4], dexMagic) {
+			return rawDexSource{data: data}, nil
+		}
+		return nil, fmt.Errorf("enjarify: unrecognized input, not a DEX file or zip archive")
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range r.File {
+		if dexIndex(f.Name) >= 0 {
+			return apkSource{r: r}, nil
+		}
+	}
+	return bundleSource{r: r}, nil
+}
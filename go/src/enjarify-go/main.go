@@ -14,14 +14,16 @@
 package main
 
 import (
-	"archive/zip"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"runtime"
+	"sort"
 	"strings"
 
-	"enjarify-go/dex"
+	"enjarify-go/enjarify"
 	"enjarify-go/jvm"
 )
 
@@ -41,75 +43,75 @@ func Write(name string, data string) {
 	check(ioutil.WriteFile(name, []byte(data), os.ModePerm))
 }
 
-func translate(opts jvm.Options, dexs ...string) (map[string]string, []string, map[string]error) {
+// translate runs enjarify.TranslateParallel over each of dexs in turn,
+// merging the results into the flat maps the rest of main expects while
+// reproducing the original cross-dex duplicate-name warning (now
+// mentioning which split a duplicate came from) and progress logging.
+// workers controls the per-dex worker pool size; pass 1 for the original,
+// fully serial behavior.
+func translate(ctx context.Context, opts jvm.Options, workers int, dexs []enjarify.NamedDex) (map[string]string, []string, map[string]error) {
 	classes := make(map[string]string)
 	errors := make(map[string]error)
 	ordkeys := []string{}
 
-	for _, data := range dexs {
-		dex := dex.Parse(data)
-		for _, cls := range dex.Classes {
-			unicode_name := Decode(cls.Name) + ".class"
-			_, ok1 := classes[unicode_name]
-			_, ok2 := errors[unicode_name]
+	for _, nd := range dexs {
+		ch, err := enjarify.TranslateParallel(ctx, nd.Split, strings.NewReader(nd.Data), opts, workers)
+		check(err)
+
+		for cls := range ch {
+			_, ok1 := classes[cls.Name]
+			_, ok2 := errors[cls.Name]
 			if ok1 || ok2 {
-				fmt.Printf("Warning, duplicate class name %s\n", unicode_name)
+				if cls.Split != "" {
+					fmt.Fprintf(os.Stderr, "Warning, duplicate class name %s (from %s)\n", cls.Name, cls.Split)
+				} else {
+					fmt.Fprintf(os.Stderr, "Warning, duplicate class name %s\n", cls.Name)
+				}
 				continue
 			}
 
-			if class_data, err := jvm.ToClassFile(cls, opts); err == nil {
-				classes[unicode_name] = class_data
-				ordkeys = append(ordkeys, unicode_name)
+			if cls.Err == nil {
+				classes[cls.Name] = cls.Data
+				ordkeys = append(ordkeys, cls.Name)
 			} else {
-				errors[unicode_name] = err
+				errors[cls.Name] = cls.Err
 			}
 
 			if (len(classes)+len(errors))%1000 == 0 {
-				fmt.Printf("%d classes processed\n", len(classes)+len(errors))
+				fmt.Fprintf(os.Stderr, "%d classes processed\n", len(classes)+len(errors))
 			}
 		}
 	}
 	return classes, ordkeys, errors
 }
 
-func writeToJar(fname string, classes map[string]string, ordkeys []string) {
-	file, err := os.Create(fname)
-	check(err)
-	defer file.Close()
-
-	w := zip.NewWriter(file)
-	defer w.Close()
+// writeClasses puts every class named in ordkeys into sink, in order, and
+// closes it.
+func writeClasses(sink enjarify.ClassSink, classes map[string]string, ordkeys []string) {
 	for _, unicode_name := range ordkeys {
-		data := classes[unicode_name]
-		f, err := w.Create(unicode_name)
-		check(err)
-		_, err = f.Write([]byte(data))
-		check(err)
+		check(sink.Put(unicode_name, classes[unicode_name]))
 	}
+	check(sink.Close())
 }
 
-func readDexes(apkname string) (res []string) {
-	r, err := zip.OpenReader(apkname)
-	check(err)
-	defer r.Close()
-
-	for _, f := range r.File {
-		if strings.HasPrefix(f.Name, "classes") && strings.HasSuffix(f.Name, ".dex") {
-			rc, err := f.Open()
-			check(err)
-			data, err := ioutil.ReadAll(rc)
-			check(err)
-			res = append(res, string(data))
-			rc.Close()
-		}
+// openOutputFile opens name for writing, requiring force if it already
+// exists, the same existence check the -f flag has always governed for
+// jar output.
+func openOutputFile(name string, force bool) (*os.File, error) {
+	mode := os.O_RDWR | os.O_CREATE
+	if !force {
+		mode |= os.O_EXCL
 	}
-	return
+	return os.OpenFile(name, mode, os.FileMode(0666))
 }
 
 func main() {
-	pout := flag.String("o", "", "Output .jar file. Default is [input-filename]-enjarify.jar.")
+	pout := flag.String("o", "", "Output file or directory. Default is [input-filename]-enjarify.jar (-enjarify for -format dir, -enjarify.tar for -format tar). Pass - to write a tar stream to stdout.")
 	pforce := flag.Bool("f", false, "Force overwrite. If output file already exists, this option is required to overwrite.")
 	pfast := flag.Bool("fast", false, "Speed up translation at the expense of generated bytecode being less readable.")
+	pjobs := flag.Int("j", runtime.NumCPU(), "Number of classes to translate in parallel. -j 1 disables the worker pool and translates serially.")
+	pformat := flag.String("format", "jar", "Output format: jar, dir (exploded directory tree), or tar (tar stream, implied by -o -).")
+	preproducible := flag.Bool("reproducible", true, "Produce byte-identical output across runs: entries get a fixed modified time (SOURCE_DATE_EPOCH if set, else 1980-01-01) instead of the current time (tar, dir) or no recorded time (jar), and are written in sorted order. Jar output additionally gets a minimal MANIFEST.MF.")
 	ptests := flag.Bool("runtests", false, "")
 	phash := flag.Bool("hashtests", false, "")
 	flag.Parse()
@@ -124,31 +126,81 @@ func main() {
 	}
 
 	if inputfile == "" {
-		fmt.Printf("Error, no input filename passed.\n")
+		fmt.Fprintf(os.Stderr, "Error, no input filename passed.\n")
 		return
 	}
 
-	dexs := []string{}
-	if strings.HasSuffix(strings.ToLower(inputfile), ".apk") {
-		dexs = readDexes(inputfile)
-	} else {
-		dexs = []string{Read(inputfile)}
-	}
+	source, err := enjarify.OpenSource(Read(inputfile))
+	check(err)
+	dexs, err := source.Dexes()
+	check(err)
 
+	format := *pformat
 	outname := *pout
+	if outname == "-" {
+		format = "tar"
+	}
+
 	if outname == "" {
 		s := inputfile[strings.LastIndex(inputfile, "/")+1:]
 		s = s[:strings.LastIndex(s, ".")]
-		outname = s + "-enjarify.jar"
+		switch format {
+		case "dir":
+			outname = s + "-enjarify"
+		case "tar":
+			outname = s + "-enjarify.tar"
+		default:
+			outname = s + "-enjarify.jar"
+		}
 	}
 
-	mode := os.O_RDWR | os.O_CREATE
-	if !*pforce {
-		mode |= os.O_EXCL
-	}
-	outfile, err := os.OpenFile(outname, mode, os.FileMode(0666))
-	if err != nil {
-		fmt.Printf("Error, output file already exists and -f was not specified. To overwrite the output file, pass -f\n")
+	epoch := enjarify.SourceDateEpoch(enjarify.DefaultEpoch)
+
+	var sink enjarify.ClassSink
+	var outfile *os.File
+	switch format {
+	case "jar", "tar":
+		if outname == "-" {
+			if *preproducible {
+				sink = enjarify.NewReproducibleTarSink(os.Stdout, epoch)
+			} else {
+				sink = enjarify.NewTarSink(os.Stdout)
+			}
+		} else {
+			var err error
+			outfile, err = openOutputFile(outname, *pforce)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error, output file already exists and -f was not specified. To overwrite the output file, pass -f\n")
+				return
+			}
+			switch {
+			case format == "jar" && *preproducible:
+				sink, err = enjarify.NewReproducibleJarSink(outfile, epoch)
+				check(err)
+			case format == "jar":
+				sink = enjarify.NewJarSink(outfile)
+			case *preproducible:
+				sink = enjarify.NewReproducibleTarSink(outfile, epoch)
+			default:
+				sink = enjarify.NewTarSink(outfile)
+			}
+		}
+	case "dir":
+		if _, err := os.Stat(outname); err == nil && !*pforce {
+			fmt.Fprintf(os.Stderr, "Error, output directory already exists and -f was not specified. To overwrite files in the output directory, pass -f\n")
+			return
+		}
+		if err := os.MkdirAll(outname, 0777); err != nil {
+			fmt.Fprintf(os.Stderr, "Error, %s\n", err.Error())
+			return
+		}
+		if *preproducible {
+			sink = enjarify.NewReproducibleDirSink(outname, epoch)
+		} else {
+			sink = enjarify.NewDirSink(outname)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error, unknown -format %s (want jar, dir, or tar)\n", format)
 		return
 	}
 
@@ -157,13 +209,18 @@ func main() {
 		opts = jvm.NONE
 	}
 
-	classes, ordkeys, errors := translate(opts, dexs...)
-	writeToJar(outname, classes, ordkeys)
-	outfile.Close()
-	fmt.Printf("Output written to %s\n", outname)
+	classes, ordkeys, errors := translate(context.Background(), opts, *pjobs, dexs)
+	if *preproducible {
+		sort.Strings(ordkeys)
+	}
+	writeClasses(sink, classes, ordkeys)
+	if outfile != nil {
+		outfile.Close()
+	}
+	fmt.Fprintf(os.Stderr, "Output written to %s\n", outname)
 
 	for name, error := range errors {
-		fmt.Printf("%s %s\n", name, error.Error())
+		fmt.Fprintf(os.Stderr, "%s %s\n", name, error.Error())
 	}
-	fmt.Printf("%d classes translated successfully, %d classes had errors\n", len(classes), len(errors))
+	fmt.Fprintf(os.Stderr, "%d classes translated successfully, %d classes had errors\n", len(classes), len(errors))
 }